@@ -0,0 +1,50 @@
+package goajax
+
+import (
+	"io"
+	"os"
+	"reflect"
+)
+
+// msgpackCodec and protobufCodec exist so the Content-Type switch in
+// codecFor has somewhere to route "application/x-msgpack" and
+// "application/x-protobuf" requests, letting mobile clients negotiate a
+// binary-efficient transport once one of these is wired up for real.
+// Neither library is vendored in this tree, so every method below returns
+// an error and neither codec is registered by NewServer: requests with
+// those content types fall back to jsonCodec, like any other unrecognized
+// Content-Type. If one is registered with server.RegisterCodec, every
+// request of its type fails: ReadRequest errors, the parse-error reply then
+// fails to encode too, and writeResponse answers with a plain-text 500.
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) ContentType() string { return "application/x-msgpack" }
+
+func (msgpackCodec) ReadRequest(r io.Reader) (*Request, os.Error) {
+	return nil, os.ErrorString("msgpack codec not available in this build")
+}
+
+func (msgpackCodec) WriteResponse(w io.Writer, resp *Response) os.Error {
+	return os.ErrorString("msgpack codec not available in this build")
+}
+
+func (msgpackCodec) UnmarshalParams(raw []byte, argTypes []reflect.Type, argNames []string) ([]reflect.Value, os.Error) {
+	return nil, os.ErrorString("msgpack codec not available in this build")
+}
+
+type protobufCodec struct{}
+
+func (protobufCodec) ContentType() string { return "application/x-protobuf" }
+
+func (protobufCodec) ReadRequest(r io.Reader) (*Request, os.Error) {
+	return nil, os.ErrorString("protobuf codec not available in this build")
+}
+
+func (protobufCodec) WriteResponse(w io.Writer, resp *Response) os.Error {
+	return os.ErrorString("protobuf codec not available in this build")
+}
+
+func (protobufCodec) UnmarshalParams(raw []byte, argTypes []reflect.Type, argNames []string) ([]reflect.Value, os.Error) {
+	return nil, os.ErrorString("protobuf codec not available in this build")
+}
@@ -0,0 +1,68 @@
+package goajax
+
+import (
+	"http"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+)
+
+// Request is the wire-agnostic shape of one JSON-RPC call: whatever the
+// Codec parsed the body into.
+type Request struct {
+	Id      []byte // raw encoded id; nil means "notification"
+	Method  string
+	Params  []byte // raw encoded params, handed to Codec.UnmarshalParams
+	Timeout int64  // optional, milliseconds; 0 means no deadline
+}
+
+// Response is the wire-agnostic shape of one JSON-RPC reply, before a
+// Codec serializes it back onto the wire.
+type Response struct {
+	Id     []byte
+	Result interface{}
+	Error  *rpcError
+}
+
+// Codec adapts ServeHTTP to a particular wire format. A Server picks a
+// registered Codec by matching the request's Content-Type against
+// ContentType(); see RegisterCodec.
+type Codec interface {
+	ReadRequest(r io.Reader) (*Request, os.Error)
+	WriteResponse(w io.Writer, resp *Response) os.Error
+	UnmarshalParams(raw []byte, argTypes []reflect.Type, argNames []string) ([]reflect.Value, os.Error)
+	ContentType() string
+}
+
+// RegisterCodec makes codec available for requests whose Content-Type
+// matches codec.ContentType(). NewServer only registers jsonCodec (also the
+// fallback used when Content-Type is absent or unrecognized); msgpackCodec
+// and protobufCodec are available to opt into with RegisterCodec but aren't
+// registered by default since neither actually encodes or decodes anything
+// yet. Calling RegisterCodec again with the same content type replaces the
+// previous codec.
+func (server *Server) RegisterCodec(codec Codec) {
+	server.Lock()
+	defer server.Unlock()
+	server.codecs[codec.ContentType()] = codec
+}
+
+// codecFor picks the Codec matching r's Content-Type, ignoring any
+// "; charset=..." parameters, and falls back to JSON.
+func (server *Server) codecFor(r *http.Request) Codec {
+	contentType := r.Header.Get("Content-Type")
+	if idx := strings.Index(contentType, ";"); idx >= 0 {
+		contentType = contentType[0:idx]
+	}
+	contentType = strings.TrimSpace(contentType)
+
+	server.Lock()
+	codec, ok := server.codecs[contentType]
+	server.Unlock()
+
+	if !ok {
+		return server.codecs[jsonContentType]
+	}
+	return codec
+}
@@ -0,0 +1,113 @@
+package goajax
+
+import (
+	"os"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// Context lets a registered method observe cancellation: a call's deadline
+// expiring, or (over ServeWebSocket) the client unsubscribing. A method
+// opts in by taking one as its first argument, e.g.
+//
+//	func (s *Service) LongJob(ctx goajax.Context, n int) (int, os.Error) {
+//		select {
+//		case <-ctx.Done():
+//			return 0, ctx.Err()
+//		case ...:
+//		}
+//	}
+//
+// register detects this by comparing the first argument's type against
+// typeOfContext, the same trick already used for typeOfOsError in server.go.
+type Context interface {
+	Done() <-chan bool
+	Err() os.Error
+}
+
+var unusedContext *Context
+var typeOfContext = reflect.Typeof(unusedContext).(*reflect.PtrType).Elem()
+
+// requestContext backs a single ServeHTTP call.
+//
+// KNOWN LIMITATION: it only fires once req.Timeout (if any) elapses. It
+// does NOT cancel when the client disconnects mid-request. Detecting that
+// needs either an http.CloseNotifier on the ResponseWriter or a cancelable
+// request context, and this package's "http" predates both - CloseNotifier
+// doesn't land until Go 1.1, well after this tree's dialect, and there is
+// no equivalent of r.Context() to fall back to. A long-running call from a
+// client that has already hung up will still run to completion
+// server-side; only its deadline (if any) bounds it. Fixing this for real
+// requires either vendoring a newer http package or layering a
+// Hijack-based connection watcher on top of ServeHTTP, neither of which
+// this change attempts.
+type requestContext struct {
+	mu   sync.Mutex
+	done chan bool
+	stop chan bool // closed by stopTimer once the call finishes on its own
+	err  os.Error
+}
+
+func newRequestContext(timeoutMillis int64) *requestContext {
+	ctx := &requestContext{done: make(chan bool)}
+	if timeoutMillis > 0 {
+		ctx.stop = make(chan bool)
+		go func() {
+			select {
+			case <-time.After(timeoutMillis * 1e6):
+				ctx.cancel(os.ErrorString("request cancelled"))
+			case <-ctx.stop:
+			}
+		}()
+	}
+	return ctx
+}
+
+// stopTimer lets the timeout goroutine, if any, return as soon as the call
+// it was guarding finishes on its own, instead of sleeping out the rest of
+// the timeout for nothing.
+func (ctx *requestContext) stopTimer() {
+	if ctx.stop != nil {
+		close(ctx.stop)
+	}
+}
+
+func (ctx *requestContext) Done() <-chan bool {
+	return ctx.done
+}
+
+func (ctx *requestContext) Err() os.Error {
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+	return ctx.err
+}
+
+func (ctx *requestContext) cancel(err os.Error) {
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+	if ctx.err != nil {
+		return
+	}
+	ctx.err = err
+	close(ctx.done)
+}
+
+// chanContext adapts a channel someone else closes (e.g. a WebSocket
+// subscription's unsubscribe signal) into a Context.
+type chanContext struct {
+	done chan bool
+}
+
+func (ctx *chanContext) Done() <-chan bool {
+	return ctx.done
+}
+
+func (ctx *chanContext) Err() os.Error {
+	select {
+	case <-ctx.done:
+		return os.ErrorString("request cancelled")
+	default:
+		return nil
+	}
+}
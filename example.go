@@ -4,6 +4,7 @@ import (
 	"http"
 	"goajax"
 	"os"
+	"time"
 )
 
 func main() {
@@ -12,6 +13,7 @@ func main() {
 
 	http.HandleFunc("/", handleIndex)
 	http.Handle("/json", s)
+	http.HandleFunc("/ws", s.ServeWebSocket)
 
 	http.ListenAndServe(":9001", nil)
 
@@ -52,3 +54,27 @@ type Service int
 func (s *Service) Add(a, b float64) (float64, os.Error) {
 	return a + b, nil
 }
+
+// Ticks is a streaming method: over /ws, subscribing to it pushes an
+// incrementing counter to the client once a second until it unsubscribes.
+// It takes a Context so its producer goroutine can stop as soon as that
+// happens, instead of blocking forever on a send nobody will ever receive.
+func (s *Service) Ticks(ctx goajax.Context) (<-chan int, os.Error) {
+	out := make(chan int)
+	go func() {
+		defer close(out)
+		for i := 0; ; i++ {
+			select {
+			case out <- i:
+			case <-ctx.Done():
+				return
+			}
+			select {
+			case <-time.After(1e9):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out, nil
+}
@@ -0,0 +1,110 @@
+package goajax
+
+import (
+	"reflect"
+)
+
+// Two meta-methods are always available, independent of anything the
+// caller registers, the same way "rpc.unsubscribe" is reserved in
+// ServeWebSocket: rpc.describe returns a JSON-schema-like description of
+// every registered service, and rpc.listMethods returns the flat list of
+// "Service.Method" strings it includes. Client code can use either to
+// generate stubs or form UIs instead of hand-coding method strings.
+
+type methodDescription struct {
+	Name    string        "name"
+	Args    []interface{} "args"
+	Returns interface{}   "returns"
+}
+
+type serviceDescription struct {
+	Name    string               "name"
+	Methods []methodDescription  "methods"
+}
+
+// callMeta dispatches the "rpc.*" meta-methods that are always available,
+// regardless of what the caller has registered.
+func (server *Server) callMeta(resp *Response, name string) *Response {
+	switch name {
+	case "describe":
+		resp.Result = server.describe()
+	case "listMethods":
+		resp.Result = server.listMethods()
+	default:
+		resp.Error = &rpcError{Code: errCodeMethodNotFound, Message: "Method not found."}
+	}
+	return resp
+}
+
+// describe walks serviceMap and builds a description of every registered
+// service, suitable for json.Marshal.
+func (server *Server) describe() []serviceDescription {
+	server.Lock()
+	defer server.Unlock()
+
+	out := make([]serviceDescription, 0, len(server.serviceMap))
+	for _, svc := range server.serviceMap {
+		sd := serviceDescription{Name: svc.name, Methods: make([]methodDescription, 0, len(svc.method))}
+		for mname, mtype := range svc.method {
+			args := make([]interface{}, len(mtype.argTypes))
+			for i, argType := range mtype.argTypes {
+				arg := map[string]interface{}{"schema": schemaFor(argType)}
+				if i < len(mtype.argNames) && mtype.argNames[i] != "" {
+					arg["name"] = mtype.argNames[i]
+				}
+				args[i] = arg
+			}
+			sd.Methods = append(sd.Methods, methodDescription{Name: mname, Args: args, Returns: schemaFor(mtype.returnType)})
+		}
+		out = append(out, sd)
+	}
+	return out
+}
+
+// listMethods returns every registered "Service.Method" string.
+func (server *Server) listMethods() []string {
+	server.Lock()
+	defer server.Unlock()
+
+	out := make([]string, 0)
+	for sname, svc := range server.serviceMap {
+		for mname := range svc.method {
+			out = append(out, sname+"."+mname)
+		}
+	}
+	return out
+}
+
+// schemaFor emits a small JSON-schema-like description of t, walking
+// struct fields recursively and using each field's tag (its JSON name, by
+// this package's convention) as the property key when present.
+func schemaFor(t reflect.Type) interface{} {
+	switch concrete := t.(type) {
+	case *reflect.PtrType:
+		return schemaFor(concrete.Elem())
+	case *reflect.StructType:
+		props := make(map[string]interface{})
+		for i := 0; i < concrete.NumField(); i++ {
+			f := concrete.Field(i)
+			name := f.Name
+			if f.Tag != "" {
+				name = f.Tag
+			}
+			props[name] = schemaFor(f.Type)
+		}
+		return map[string]interface{}{"type": "object", "properties": props}
+	case *reflect.SliceType:
+		return map[string]interface{}{"type": "array", "items": schemaFor(concrete.Elem())}
+	case *reflect.ChanType:
+		return map[string]interface{}{"type": "array", "items": schemaFor(concrete.Elem()), "stream": true}
+	case *reflect.StringType:
+		return map[string]interface{}{"type": "string"}
+	case *reflect.BoolType:
+		return map[string]interface{}{"type": "boolean"}
+	case *reflect.IntType:
+		return map[string]interface{}{"type": "integer"}
+	case *reflect.FloatType:
+		return map[string]interface{}{"type": "number"}
+	}
+	return map[string]interface{}{"type": t.String()}
+}
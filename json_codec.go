@@ -0,0 +1,239 @@
+package goajax
+
+import (
+	"http"
+	"io"
+	"ioutil"
+	"json"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const jsonContentType = "application/json"
+
+// jsonCodec is the default Codec: plain JSON-RPC 2.0 over HTTP. It is the
+// only codec that understands batch requests, since batching is a
+// JSON-RPC convention rather than something every wire format needs.
+type jsonCodec struct{}
+
+func (jsonCodec) ContentType() string { return jsonContentType }
+
+type wireRequest struct {
+	Id      *json.RawMessage "id"
+	Method  string           "method"
+	Params  *json.RawMessage "params"
+	Timeout int64            "timeout"
+}
+
+type wireResponse struct {
+	Jsonrpc string           "jsonrpc"
+	Id      *json.RawMessage "id"
+	Result  interface{}      "result"
+	Error   *rpcError        "error"
+}
+
+func (jsonCodec) ReadRequest(r io.Reader) (*Request, os.Error) {
+	body, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return wireToRequest(body)
+}
+
+func wireToRequest(body []byte) (*Request, os.Error) {
+	wire := new(wireRequest)
+	if err := json.Unmarshal(body, wire); err != nil {
+		return nil, err
+	}
+
+	req := &Request{Method: wire.Method, Timeout: wire.Timeout}
+	if wire.Id != nil {
+		req.Id = []byte(*wire.Id)
+	}
+	if wire.Params != nil {
+		req.Params = []byte(*wire.Params)
+	}
+	return req, nil
+}
+
+func (jsonCodec) WriteResponse(w io.Writer, resp *Response) os.Error {
+	enc := json.NewEncoder(w)
+	return enc.Encode(responseToWire(resp))
+}
+
+func responseToWire(resp *Response) *wireResponse {
+	wire := &wireResponse{Jsonrpc: "2.0", Result: resp.Result, Error: resp.Error}
+	if resp.Id != nil {
+		raw := json.RawMessage(resp.Id)
+		wire.Id = &raw
+	}
+	return wire
+}
+
+// UnmarshalParams decodes raw, which may be either a JSON array (positional
+// arguments, in declaration order) or a JSON object keyed by argument name.
+// argNames is parallel to argTypes and is only consulted for the object
+// form; it may be nil, in which case object-style params are rejected.
+func (jsonCodec) UnmarshalParams(raw []byte, argTypes []reflect.Type, argNames []string) ([]reflect.Value, os.Error) {
+	if raw == nil {
+		if len(argTypes) == 0 {
+			return make([]reflect.Value, 0), nil
+		}
+		return nil, os.ErrorString("Incorrect number of parameters.")
+	}
+
+	if strings.HasPrefix(strings.TrimSpace(string(raw)), "{") {
+		return unmarshalNamedParams(raw, argTypes, argNames)
+	}
+
+	params := make([]*json.RawMessage, 0)
+	err := json.Unmarshal(raw, &params)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(params) != len(argTypes) {
+		return nil, os.ErrorString("Incorrect number of parameters.")
+	}
+
+	args := make([]reflect.Value, 0, len(argTypes))
+
+	for i, argType := range argTypes {
+		arg, err := unmarshalArg(params[i], argType, i+1)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	return args, nil
+}
+
+// unmarshalNamedParams decodes a JSON-object params value, matching each
+// key against argNames. Arguments whose name is missing from the object are
+// left at their zero value.
+func unmarshalNamedParams(raw []byte, argTypes []reflect.Type, argNames []string) ([]reflect.Value, os.Error) {
+	if len(argNames) != len(argTypes) {
+		return nil, os.ErrorString("Named parameters not supported for this method.")
+	}
+
+	named := make(map[string]*json.RawMessage)
+	if err := json.Unmarshal(raw, &named); err != nil {
+		return nil, err
+	}
+
+	args := make([]reflect.Value, 0, len(argTypes))
+
+	for i, argType := range argTypes {
+		arg, present := named[argNames[i]]
+		if !present {
+			args = append(args, reflect.MakeZero(argType))
+			continue
+		}
+		value, err := unmarshalArg(arg, argType, i+1)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, value)
+	}
+	return args, nil
+}
+
+// unmarshalArg decodes a single JSON value into argType, which is either a
+// pointer to a struct (decoded directly) or a plain value (decoded via an
+// interface{} and coerced, since the JSON package only knows float64,
+// string, bool, etc). pos is the 1-based argument position, used in error
+// messages.
+func unmarshalArg(raw *json.RawMessage, argType reflect.Type, pos int) (reflect.Value, os.Error) {
+	argPointerType, ok := argType.(*reflect.PtrType)
+
+	if ok {
+		argPointer := reflect.MakeZero(argType).(*reflect.PtrValue)
+		argPointer.PointTo(reflect.MakeZero(argPointerType.Elem()))
+		err := json.Unmarshal(*raw, argPointer.Interface())
+		if err != nil {
+			return nil, os.ErrorString("Type mismatch parameter " + strconv.Itoa(pos) + ".")
+		}
+
+		return reflect.Value(argPointer), nil
+	}
+
+	arg := reflect.MakeZero(argType)
+	var v interface{}
+	err := json.Unmarshal(*raw, &v)
+	if err != nil {
+		return nil, os.ErrorString("Type mismatch parameter " + strconv.Itoa(pos) + ".")
+	}
+	value := reflect.NewValue(v)
+	if value.Type() == arg.Type() {
+		arg.SetValue(value)
+	} else if _, ok1 := value.Type().(*reflect.FloatType); ok1 {
+		_, ok2 := argType.(*reflect.IntType)
+		if ok2 {
+			newValue := reflect.NewValue(int(v.(float64)))
+			arg.SetValue(newValue)
+		} else {
+			return nil, os.ErrorString("Type mismatch parameter " + strconv.Itoa(pos) + ".")
+		}
+	} else {
+		return nil, os.ErrorString("Type mismatch parameter " + strconv.Itoa(pos) + ".")
+	}
+	return reflect.Value(arg), nil
+}
+
+// serveBatch handles a JSON array of request objects, dispatching each
+// concurrently. Notifications (requests with no id) are executed but
+// produce no entry in the reply; if every request in the batch is a
+// notification, no body is sent at all, per the JSON-RPC 2.0 spec.
+func (server *Server) serveBatch(w http.ResponseWriter, body []byte) {
+	wireReqs := make([]wireRequest, 0)
+	if err := json.Unmarshal(body, &wireReqs); err != nil {
+		writeParseError(w, jsonCodec{})
+		return
+	}
+
+	if len(wireReqs) == 0 {
+		writeResponseError(w, nil, errCodeInvalidRequest, "Invalid Request.", jsonCodec{})
+		return
+	}
+
+	responses := make([]*Response, len(wireReqs))
+	var wg sync.WaitGroup
+	for i := range wireReqs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			req := &Request{Method: wireReqs[i].Method, Timeout: wireReqs[i].Timeout}
+			if wireReqs[i].Id != nil {
+				req.Id = []byte(*wireReqs[i].Id)
+			}
+			if wireReqs[i].Params != nil {
+				req.Params = []byte(*wireReqs[i].Params)
+			}
+			resp := server.call(req, jsonCodec{})
+			if !isNotification(req) {
+				responses[i] = resp
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	out := make([]*wireResponse, 0, len(responses))
+	for _, resp := range responses {
+		if resp != nil {
+			out = append(out, responseToWire(resp))
+		}
+	}
+
+	if len(out) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	w.SetHeader("Content-Type", jsonContentType+"; charset=utf-8")
+	enc := json.NewEncoder(w)
+	enc.Encode(out)
+}
@@ -8,9 +8,19 @@ import (
 	"os"
 	"unicode"
 	"http"
-	"json"
 	"strings"
-	"strconv"
+	"ioutil"
+	"bytes"
+)
+
+// Standard JSON-RPC 2.0 error codes. See the spec's "Error object" section.
+const (
+	errCodeParse          = -32700
+	errCodeInvalidRequest = -32600
+	errCodeMethodNotFound = -32601
+	errCodeInvalidParams  = -32602
+	errCodeInternal       = -32603
+	errCodeCancelled      = -32000
 )
 
 type service struct {
@@ -25,31 +35,30 @@ type methodType struct {
 	sync.Mutex // protects counters
 	method     reflect.Method
 	argTypes   []reflect.Type
+	argNames   []string // names given to RegisterNamed for this method, else nil; used only if len matches argTypes
 	returnType reflect.Type
+	streaming  bool // true if returnType is a channel: a subscription method
+	hasContext bool // true if the first argument is a Context
 	numCalls   uint
 }
 
 type Server struct {
-	sync.Mutex // protects the serviceMap
+	sync.Mutex // protects the serviceMap and codecs
 	serviceMap map[string]*service
+	codecs     map[string]Codec
 }
 
-
-type jsonRequest struct {
-	Id      *json.RawMessage  "id"
-	Method  string            "method"
-	Params  *json.RawMessage  "params"
-}
-
-type jsonResponse struct {
-	Id      *json.RawMessage  "id"
-	Result  interface{}       "result"
-	Error   interface{}       "error"
+type rpcError struct {
+	Code    int         "code"
+	Message string      "message"
+	Data    interface{} "data"
 }
 
 func NewServer() *Server {
 	s := new(Server)
 	s.serviceMap = make(map[string]*service)
+	s.codecs = make(map[string]Codec)
+	s.RegisterCodec(jsonCodec{})
 	return s
 }
 
@@ -58,7 +67,7 @@ func NewServer() *Server {
 var unusedError *os.Error
 var typeOfOsError = reflect.Typeof(unusedError).(*reflect.PtrType).Elem()
 
-func (server *Server) register(rcvr interface{}, name string, useName bool) os.Error {
+func (server *Server) register(rcvr interface{}, name string, useName bool, argNames map[string][]string) os.Error {
 	server.Lock()
 	defer server.Unlock()
 	
@@ -93,8 +102,15 @@ func (server *Server) register(rcvr interface{}, name string, useName bool) os.E
 		}
 		
 		args := []reflect.Type{}
-		
-		for i := 1; i < mtype.NumIn(); i++ {
+
+		argStart := 1
+		hasContext := false
+		if mtype.NumIn() > 1 && mtype.In(1) == typeOfContext {
+			hasContext = true
+			argStart = 2
+		}
+
+		for i := argStart; i < mtype.NumIn(); i++ {
 			argType := mtype.In(i)
 			if argPointerType, ok := argType.(*reflect.PtrType); ok {
 				if argPointerType.Elem().PkgPath() != "" && !isExported(argPointerType.Elem().Name()) {
@@ -111,18 +127,23 @@ func (server *Server) register(rcvr interface{}, name string, useName bool) os.E
 		}
 		
 		returnType := mtype.Out(0)
+		_, streaming := returnType.(*reflect.ChanType)
+		if streaming && !hasContext {
+			log.Println("method", mname, "is streaming but takes no Context; subscribers would have no way to stop its producer goroutine")
+			continue
+		}
 		if returnPointerType, ok := returnType.(*reflect.PtrType); ok {
 			if returnPointerType.Elem().PkgPath() != "" && !isExported(returnPointerType.Elem().Name()) {
 				log.Println(mname, "return type not exported:", returnPointerType.Elem().Name())
 				continue
 			}
 		}
-		
+
 		if errorType := mtype.Out(1); errorType != typeOfOsError {
 			log.Println("method", mname, "returns", errorType.String(), "not os.Error")
 			continue
 		}
-		s.method[mname] = &methodType{method: method, argTypes: args, returnType: returnType}
+		s.method[mname] = &methodType{method: method, argTypes: args, argNames: argNames[mname], returnType: returnType, streaming: streaming, hasContext: hasContext}
 	}
 
 	if len(s.method) == 0 {
@@ -135,11 +156,19 @@ func (server *Server) register(rcvr interface{}, name string, useName bool) os.E
 }
 
 func (server *Server) Register(rcvr interface{}) os.Error {
-	return server.register(rcvr, "", false)
+	return server.register(rcvr, "", false, nil)
 }
 
 func (server *Server) RegisterName(name string, rcvr interface{}) os.Error {
-	return server.register(rcvr, name, true)
+	return server.register(rcvr, name, true, nil)
+}
+
+// RegisterNamed is like Register but also records the argument names for
+// each method, keyed by method name. This lets callers send "params" as a
+// JSON object (e.g. {"a": 1, "b": 2}) instead of a positional array; any
+// argument name omitted from argNames falls back to positional-only calls.
+func (server *Server) RegisterNamed(rcvr interface{}, argNames map[string][]string) os.Error {
+	return server.register(rcvr, "", false, argNames)
 }
 
 func _new(t *reflect.PtrType) *reflect.PtrValue {
@@ -155,129 +184,164 @@ func isExported(name string) bool {
 }
 
 
+// ServeHTTP picks a Codec by the request's Content-Type header (defaulting
+// to JSON), then hands off to that codec's framing: jsonCodec additionally
+// recognizes a top-level JSON array as a batch of requests, since batching
+// is a JSON-RPC convention rather than something every wire format needs.
 func (server *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	
-	dec := json.NewDecoder(r.Body)
-	req := new(jsonRequest)
-	err := dec.Decode(req)
-	
+	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
-		s := "Invalid JSON-RPC."
-		sendError(w, s)
+		writeParseError(w, jsonCodec{})
 		return
 	}
-	
+
+	codec := server.codecFor(r)
+
+	if _, isJSON := codec.(jsonCodec); isJSON {
+		trimmed := strings.TrimSpace(string(body))
+		if trimmed == "" {
+			writeParseError(w, codec)
+			return
+		}
+		if trimmed[0] == '[' {
+			server.serveBatch(w, body)
+			return
+		}
+	}
+
+	server.serveSingle(w, body, codec)
+}
+
+// serveSingle handles a lone JSON-RPC request object framed by codec.
+func (server *Server) serveSingle(w http.ResponseWriter, body []byte, codec Codec) {
+	req, err := codec.ReadRequest(bytes.NewBuffer(body))
+	if err != nil {
+		writeParseError(w, codec)
+		return
+	}
+
+	resp := server.call(req, codec)
+
+	if isNotification(req) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	writeResponse(w, resp, codec)
+}
+
+// isNotification reports whether req carries no id (or an explicit JSON
+// null id), which marks it as a JSON-RPC notification: it is still
+// executed, but the caller gets no response for it.
+func isNotification(req *Request) bool {
+	return req.Id == nil || string(req.Id) == "null"
+}
+
+// call dispatches a single JSON-RPC request and always returns a response,
+// even for notifications; it is up to the caller to decide whether to send
+// that response on.
+func (server *Server) call(req *Request, codec Codec) *Response {
+	resp := new(Response)
+	resp.Id = req.Id
+
+	if req.Method == "" {
+		resp.Error = &rpcError{Code: errCodeInvalidRequest, Message: "Invalid Request."}
+		return resp
+	}
+
 	serviceMethod := strings.Split(req.Method, ".", -1)
+	if len(serviceMethod) != 2 {
+		resp.Error = &rpcError{Code: errCodeMethodNotFound, Message: "Method not found."}
+		return resp
+	}
+
+	if serviceMethod[0] == "rpc" {
+		return server.callMeta(resp, serviceMethod[1])
+	}
+
 	server.Lock()
 	service, ok := server.serviceMap[serviceMethod[0]]
 	server.Unlock()
-	
+
 	if !ok {
-		s := "Service not found."
-		sendError(w, s)
-		return	
+		resp.Error = &rpcError{Code: errCodeMethodNotFound, Message: "Service not found."}
+		return resp
 	}
-	
+
 	mtype, ok := service.method[serviceMethod[1]]
 	if !ok {
-		s := "Method not found."
-		sendError(w, s)
-		return
+		resp.Error = &rpcError{Code: errCodeMethodNotFound, Message: "Method not found."}
+		return resp
 	}
-	
-	args, err := getParams(req, mtype.argTypes)
-	
+
+	args, err := codec.UnmarshalParams(req.Params, mtype.argTypes, mtype.argNames)
+
 	if err != nil {
-		sendError(w, err.String())
-		return
+		resp.Error = &rpcError{Code: errCodeInvalidParams, Message: err.String()}
+		return resp
+	}
+
+	var ctx *requestContext
+	if mtype.hasContext {
+		ctx = newRequestContext(req.Timeout)
+		args = append([]reflect.Value{service.rcvr, reflect.NewValue(Context(ctx))}, args...)
+	} else {
+		args = append([]reflect.Value{service.rcvr}, args...)
 	}
-		
-	args = append([]reflect.Value{service.rcvr}, args...)
 
 	mtype.Lock()
 	mtype.numCalls++
 	mtype.Unlock()
 	function := mtype.method.Func
-	
-	returnValues := function.Call(args)
-	
-	// The return value for the method is an os.Error.
+
+	if ctx == nil {
+		return finishCall(resp, function.Call(args))
+	}
+
+	done := make(chan []reflect.Value, 1)
+	go func() {
+		done <- function.Call(args)
+	}()
+
+	select {
+	case returnValues := <-done:
+		ctx.stopTimer()
+		return finishCall(resp, returnValues)
+	case <-ctx.Done():
+		resp.Error = &rpcError{Code: errCodeCancelled, Message: "request cancelled"}
+		return resp
+	}
+}
+
+// finishCall fills resp from a method's (result, os.Error) return values.
+func finishCall(resp *Response, returnValues []reflect.Value) *Response {
 	errInter := returnValues[1].Interface()
-	errmsg := ""
 	if errInter != nil {
-		errmsg = errInter.(os.Error).String()
+		resp.Error = &rpcError{Code: errCodeInternal, Message: errInter.(os.Error).String()}
+		return resp
 	}
-	
-	resp := new(jsonResponse)
-	
-	if errmsg != "" {
-		resp.Error = errmsg
-	} else {
-		resp.Result = returnValues[0].Interface()
-	}
-	
-	resp.Id = req.Id
-	
-	
-	w.SetHeader("Content-Type", "application/json; charset=utf-8")
-	enc := json.NewEncoder(w)
-	enc.Encode(resp)
+
+	resp.Result = returnValues[0].Interface()
+	return resp
 }
 
-func sendError(w http.ResponseWriter, s string) {
-	w.SetHeader("Content-Type", "application/json; charset=utf-8")
-	w.Write([]byte("{\"jsonrpc\": \"2.0\", \"id\":null, \"error\":\"" + s + "\"}"))
+func writeParseError(w http.ResponseWriter, codec Codec) {
+	writeResponseError(w, nil, errCodeParse, "Parse error.", codec)
 }
 
-func getParams(req *jsonRequest, argTypes []reflect.Type) ([]reflect.Value, os.Error) {
-	params := make([]*json.RawMessage, 0)
-	err := json.Unmarshal(*req.Params, &params)
-	
-	if err != nil {
-		return nil, err
-	}
-	
-	if len(params) != len(argTypes) {
-		return nil, os.ErrorString("Incorrect number of parameters.")
-	}
-	
-	args := make([]reflect.Value, 0, len(argTypes))
-	
-	for i, argType := range argTypes {
-		argPointerType, ok := argType.(*reflect.PtrType)
-		
-		if ok {
-				argPointer := reflect.MakeZero(argType).(*reflect.PtrValue)
-				argPointer.PointTo(reflect.MakeZero(argPointerType.Elem()))
-				err := json.Unmarshal(*params[i], argPointer.Interface())
-				if err != nil {
-					return nil, os.ErrorString("Type mismatch parameter "+strconv.Itoa(i+1) + ".")
-				}
-				
-				args = append(args, reflect.Value(argPointer))
-		} else {
-				arg := reflect.MakeZero(argType)
-				var v interface{}
-				err := json.Unmarshal(*params[i], &v)
-				if err != nil {
-					return nil, os.ErrorString("Type mismatch parameter "+strconv.Itoa(i+1) + ".")
-				}
-				value := reflect.NewValue(v)
-				if value.Type() == arg.Type() {
-					arg.SetValue(value)
-				} else if _, ok1 := value.Type().(*reflect.FloatType); ok1 {
-					_, ok2 := argType.(*reflect.IntType)
-					if ok2 {
-						newValue := reflect.NewValue(int(v.(float64)))
-						arg.SetValue(newValue)
-					} else {
-						return nil, os.ErrorString("Type mismatch parameter "+strconv.Itoa(i+1) + ".")
-					}
-				} else {
-					return nil, os.ErrorString("Type mismatch parameter "+strconv.Itoa(i+1) + ".")
-				}
-				args = append(args, reflect.Value(arg))
-		}
+func writeResponseError(w http.ResponseWriter, id []byte, code int, message string, codec Codec) {
+	writeResponse(w, &Response{Id: id, Error: &rpcError{Code: code, Message: message}}, codec)
+}
+
+// writeResponse writes resp through codec. If the codec itself fails to
+// encode resp, that failure can't be reported through the same codec, so
+// it's surfaced as a plain-text 500 instead of silently sending a 200 with
+// an empty or truncated body.
+func writeResponse(w http.ResponseWriter, resp *Response, codec Codec) {
+	w.SetHeader("Content-Type", codec.ContentType()+"; charset=utf-8")
+	if err := codec.WriteResponse(w, resp); err != nil {
+		w.SetHeader("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("rpc: " + err.String()))
 	}
-	return args, nil
 }
\ No newline at end of file
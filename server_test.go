@@ -10,6 +10,8 @@ import (
 	"io"
 	"json"
 	"strconv"
+	"sync"
+	"time"
 )
 
 type TestService int
@@ -34,6 +36,39 @@ func (s *TestService) ObjAdd(obj1, obj2 *A) (*A, os.Error) {
 	return out, nil
 }
 
+func (s *TestService) Slow(ctx Context, ms int64) (string, os.Error) {
+	select {
+	case <-ctx.Done():
+		return "", ctx.Err()
+	case <-time.After(ms * 1e6):
+		return "done", nil
+	}
+}
+
+func (s *TestService) Stream(ctx Context) (<-chan int, os.Error) {
+	out := make(chan int)
+	close(out)
+	return out, nil
+}
+
+// Counter pushes 0..n-1 and closes, stopping early if ctx is cancelled
+// first; it exists so subscribe() can be driven with a predictable,
+// non-time-based streaming method in tests.
+func (s *TestService) Counter(ctx Context, n int) (<-chan int, os.Error) {
+	out := make(chan int)
+	go func() {
+		defer close(out)
+		for i := 0; i < n; i++ {
+			select {
+			case out <- i:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out, nil
+}
+
 func (s *TestService) Unrepeat(in string) (*A, os.Error) {
 	runes := []int(in)
 	j := -1
@@ -66,6 +101,26 @@ func TestRegisteringWithName(t *testing.T) {
 	s.RegisterName("service", new(TestService))
 }
 
+func TestRegisteringDetectsStreamingMethods(t *testing.T) {
+	s := NewServer()
+	s.Register(new(TestService))
+
+	svc := s.serviceMap["TestService"]
+	if !svc.method["Stream"].streaming {
+		t.Error("Stream should be registered as a streaming method")
+		t.Fail()
+	}
+	if svc.method["Add"].streaming {
+		t.Error("Add should not be registered as a streaming method")
+		t.Fail()
+	}
+}
+
+func TestRegisteringNamed(t *testing.T) {
+	s := NewServer()
+	s.RegisterNamed(new(TestService), map[string][]string{"Add": []string{"a", "b"}})
+}
+
 type test struct {
 	req       string
 	resp  interface{}
@@ -77,7 +132,7 @@ var tests = []test {
 	test{req: `{"jsonrpc": "2.0", "method":"TestService.NonExistent","params":[40, 2], "id":0}`, resp: nil, error: "Method not found."},
 	test{req: `{"jsonrpc": "2.0", "method":"OtherService.Add","params":[40, 2], "id":0}`, resp: nil, error: "Service not found."},
 	test{req: `{"jsonrpc": "2.0", "method":"TestService.Add","params":[1, 2.23], "id":0}`, resp: 3.23, error: nil},
-	test{req: `{"jsonrpc": "2.0", "method":"TestService.Add","params":[40, 2], "id":0`, resp: nil, error: "Invalid JSON-RPC."},
+	test{req: `{"jsonrpc": "2.0", "method":"TestService.Add","params":[40, 2], "id":0`, resp: nil, error: "Parse error."},
 	test{req: `{"jsonrpc": "2.0", "method":"TestService.Repeat","params":[{"x": "str", "y": 3}], "id":0}`, resp: "strstrstr", error: nil},
 	test{req: `{"jsonrpc": "2.0", "method":"TestService.Repeat","params":["str"], "id":0}`, resp: nil, error: "Type mismatch parameter 1."},
 	test{req: `{"jsonrpc": "2.0", "method":"TestService.Unrepeat","params":["strstrstr"], "id":0}`, resp: map[string]interface{}{"x":"str", "y":3}, error: nil},
@@ -91,10 +146,185 @@ type A struct {
 	Y   int      "y"
 }
 
+func TestCallBatch(t *testing.T) {
+	s := NewServer()
+	s.Register(new(TestService))
+
+	req := `[{"jsonrpc": "2.0", "method":"TestService.Add","params":[1, 2], "id":1},` +
+		`{"jsonrpc": "2.0", "method":"TestService.Add","params":[3, 4]},` +
+		`{"jsonrpc": "2.0", "method":"TestService.Add","params":[5, 6], "id":2}]`
+	str := "POST /json HTTP/1.1\nContent-Length: " + strconv.Itoa(len(req)) + "\n\n" + req
+	r := bufio.NewReader(strings.NewReader(str))
+
+	httpReq, _ := http.ReadRequest(r)
+	b := bytes.NewBuffer([]byte{})
+	w := &TestResponseWriter{buffer: b}
+	s.ServeHTTP(w, httpReq)
+
+	resps := make([]*wireResponse, 0)
+	json.Unmarshal(b.Bytes(), &resps)
+
+	// the notification (no "id") must not produce a response
+	if len(resps) != 2 {
+		t.Error("Expected 2 responses, got", len(resps))
+		t.Fail()
+	}
+}
+
+func TestCallAllNotifications(t *testing.T) {
+	s := NewServer()
+	s.Register(new(TestService))
+
+	req := `[{"jsonrpc": "2.0", "method":"TestService.Add","params":[1, 2]}]`
+	str := "POST /json HTTP/1.1\nContent-Length: " + strconv.Itoa(len(req)) + "\n\n" + req
+	r := bufio.NewReader(strings.NewReader(str))
+
+	httpReq, _ := http.ReadRequest(r)
+	b := bytes.NewBuffer([]byte{})
+	w := &TestResponseWriter{buffer: b}
+	s.ServeHTTP(w, httpReq)
+
+	if b.Len() != 0 {
+		t.Error("Expected empty body for an all-notification batch, got", b.String())
+		t.Fail()
+	}
+}
+
+func TestCallWithTimeout(t *testing.T) {
+	s := NewServer()
+	s.Register(new(TestService))
+
+	req := `{"jsonrpc": "2.0", "method":"TestService.Slow","params":[50], "id":1, "timeout":1}`
+	str := "POST /json HTTP/1.1\nContent-Length: " + strconv.Itoa(len(req)) + "\n\n" + req
+	r := bufio.NewReader(strings.NewReader(str))
+
+	httpReq, _ := http.ReadRequest(r)
+	b := bytes.NewBuffer([]byte{})
+	w := &TestResponseWriter{buffer: b}
+	s.ServeHTTP(w, httpReq)
+
+	resp := new(wireResponse)
+	json.Unmarshal(b.Bytes(), resp)
+
+	if resp.Error == nil || resp.Error.Code != errCodeCancelled {
+		t.Error("Expected a cancellation error, got", resp.Error)
+		t.Fail()
+	}
+}
+
+func TestRpcListMethods(t *testing.T) {
+	s := NewServer()
+	s.Register(new(TestService))
+
+	req := `{"jsonrpc": "2.0", "method":"rpc.listMethods","params":[], "id":0}`
+	str := "POST /json HTTP/1.1\nContent-Length: " + strconv.Itoa(len(req)) + "\n\n" + req
+	r := bufio.NewReader(strings.NewReader(str))
+
+	httpReq, _ := http.ReadRequest(r)
+	b := bytes.NewBuffer([]byte{})
+	w := &TestResponseWriter{buffer: b}
+	s.ServeHTTP(w, httpReq)
+
+	resp := new(wireResponse)
+	json.Unmarshal(b.Bytes(), resp)
+
+	if resp.Error != nil {
+		t.Error("Unexpected error:", resp.Error)
+		t.Fail()
+		return
+	}
+	methods, ok := resp.Result.([]interface{})
+	if !ok || len(methods) == 0 {
+		t.Error("Expected a non-empty method list, got", resp.Result)
+		t.Fail()
+	}
+}
+
+func TestRpcDescribe(t *testing.T) {
+	s := NewServer()
+	s.Register(new(TestService))
+
+	req := `{"jsonrpc": "2.0", "method":"rpc.describe","params":[], "id":0}`
+	str := "POST /json HTTP/1.1\nContent-Length: " + strconv.Itoa(len(req)) + "\n\n" + req
+	r := bufio.NewReader(strings.NewReader(str))
+
+	httpReq, _ := http.ReadRequest(r)
+	b := bytes.NewBuffer([]byte{})
+	w := &TestResponseWriter{buffer: b}
+	s.ServeHTTP(w, httpReq)
+
+	resp := new(wireResponse)
+	json.Unmarshal(b.Bytes(), resp)
+
+	if resp.Error != nil {
+		t.Error("Unexpected error:", resp.Error)
+		t.Fail()
+		return
+	}
+	services, ok := resp.Result.([]interface{})
+	if !ok || len(services) == 0 {
+		t.Error("Expected a non-empty service description, got", resp.Result)
+		t.Fail()
+	}
+}
+
+func TestUnrecognizedContentTypeFallsBackToJSON(t *testing.T) {
+	s := NewServer()
+	s.Register(new(TestService))
+
+	req := `{"jsonrpc": "2.0", "method":"TestService.Add","params":[1, 2], "id":0}`
+	str := "POST /json HTTP/1.1\nContent-Type: text/plain\nContent-Length: " + strconv.Itoa(len(req)) + "\n\n" + req
+	r := bufio.NewReader(strings.NewReader(str))
+
+	httpReq, _ := http.ReadRequest(r)
+	b := bytes.NewBuffer([]byte{})
+	w := &TestResponseWriter{buffer: b}
+	s.ServeHTTP(w, httpReq)
+
+	resp := new(wireResponse)
+	json.Unmarshal(b.Bytes(), resp)
+
+	if resp.Error != nil {
+		t.Error("Unexpected error:", resp.Error)
+		t.Fail()
+	}
+	if fValue, ok := resp.Result.(float64); !ok || fValue != 3.00 {
+		t.Error("Did not match float")
+		t.Fail()
+	}
+}
+
+func TestCallNamedParams(t *testing.T) {
+	s := NewServer()
+	s.RegisterNamed(new(TestService), map[string][]string{"Add": []string{"a", "b"}})
+
+	req := `{"jsonrpc": "2.0", "method":"TestService.Add","params":{"a": 40, "b": 2}, "id":0}`
+	str := "POST /json HTTP/1.1\nContent-Length: " + strconv.Itoa(len(req)) + "\n\n" + req
+	r := bufio.NewReader(strings.NewReader(str))
+
+	httpReq, _ := http.ReadRequest(r)
+	b := bytes.NewBuffer([]byte{})
+	w := &TestResponseWriter{buffer: b}
+	s.ServeHTTP(w, httpReq)
+
+	resp := new(wireResponse)
+	json.Unmarshal(b.Bytes(), resp)
+
+	if resp.Error != nil {
+		t.Error("Unexpected error:", resp.Error)
+		t.Fail()
+		return
+	}
+	if fValue, ok := resp.Result.(float64); !ok || fValue != 42.00 {
+		t.Error("Did not match float")
+		t.Fail()
+	}
+}
+
 func TestCall(t *testing.T) {
 	s := NewServer()
 	s.Register(new(TestService))
-	
+
 	for i, test := range tests {
 		str := "POST /json HTTP/1.1\nContent-Length: " + strconv.Itoa(len(test.req)) + "\n\n"+test.req
 		r := bufio.NewReader(strings.NewReader(str))
@@ -103,7 +333,7 @@ func TestCall(t *testing.T) {
 		b := bytes.NewBuffer([]byte{})
 		w := &TestResponseWriter{buffer: b}
 		s.ServeHTTP(w, req)
-		resp := new(jsonResponse)
+		resp := new(wireResponse)
 		json.Unmarshal(b.Bytes(), resp)
 		
 		if test.error != nil {
@@ -112,15 +342,15 @@ func TestCall(t *testing.T) {
 				t.Fail()
 				return
 			} else {
-				if test.error.(string) != resp.Error.(string) {
-					t.Error("Test", i, resp.Error.(string))
+				if test.error.(string) != resp.Error.Message {
+					t.Error("Test", i, resp.Error.Message)
 					t.Fail()
 					return
 				}
 			}
 		} else {
 			if resp.Error != nil {
-				t.Error("Test", i, resp.Error.(string))
+				t.Error("Test", i, resp.Error.Message)
 				t.Fail()
 				return
 			}
@@ -199,4 +429,129 @@ func (t *TestResponseWriter) Flush() {
 }
 func (t *TestResponseWriter) Hijack() (io.ReadWriteCloser, *bufio.ReadWriter, os.Error) {
 	return nil, nil, nil
+}
+
+// fakeWsConn is a wsConn that records what was sent to it, so subscribe()
+// can be driven and asserted on without a real WebSocket.
+type fakeWsConn struct {
+	mu   sync.Mutex
+	sent []*wireResponse
+}
+
+func (c *fakeWsConn) Send(v interface{}) os.Error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sent = append(c.sent, v.(*wireResponse))
+	return nil
+}
+
+func (c *fakeWsConn) count() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.sent)
+}
+
+func TestSubscribePushesValuesUntilChannelCloses(t *testing.T) {
+	s := NewServer()
+	s.Register(new(TestService))
+
+	svc := s.serviceMap["TestService"]
+	mtype := svc.method["Counter"]
+
+	req := &Request{Id: []byte("1"), Params: []byte(`[3]`)}
+	conn := &fakeWsConn{}
+	cancel := make(chan bool)
+
+	s.subscribe(conn, svc, mtype, req, cancel)
+
+	if conn.count() != 3 {
+		t.Error("Expected 3 pushed values, got", conn.count())
+		t.Fail()
+		return
+	}
+	for i, resp := range conn.sent {
+		if iValue, ok := resp.Result.(int); !ok || iValue != i {
+			t.Error("Value", i, "did not match, got", resp.Result)
+			t.Fail()
+		}
+		if resp.Id == nil || string(*resp.Id) != "1" {
+			t.Error("Value", i, "did not carry the subscription's id")
+			t.Fail()
+		}
+	}
+}
+
+func TestSubscribeStopsOnCancel(t *testing.T) {
+	s := NewServer()
+	s.Register(new(TestService))
+
+	svc := s.serviceMap["TestService"]
+	mtype := svc.method["Counter"]
+
+	req := &Request{Id: []byte("1"), Params: []byte(`[1000000]`)}
+	conn := &fakeWsConn{}
+	cancel := make(chan bool)
+
+	done := make(chan bool)
+	go func() {
+		s.subscribe(conn, svc, mtype, req, cancel)
+		close(done)
+	}()
+
+	close(cancel)
+
+	select {
+	case <-done:
+	case <-time.After(1e9):
+		t.Error("subscribe did not return promptly after cancel")
+		t.Fail()
+		return
+	}
+
+	if conn.count() >= 1000000 {
+		t.Error("Expected subscribe to stop well short of the full count, got", conn.count())
+		t.Fail()
+	}
+}
+
+func TestSubscribeRejectsMissingId(t *testing.T) {
+	if !missingSubscriptionId(&Request{Id: nil}) {
+		t.Error("Expected a nil id to be rejected")
+		t.Fail()
+	}
+	if !missingSubscriptionId(&Request{Id: []byte("null")}) {
+		t.Error("Expected an explicit null id to be rejected")
+		t.Fail()
+	}
+	if missingSubscriptionId(&Request{Id: []byte("1")}) {
+		t.Error("Expected a real id to be accepted")
+		t.Fail()
+	}
+}
+
+func TestRemoveSubscriptionCleansUpMap(t *testing.T) {
+	var mu sync.Mutex
+	cancel := make(chan bool)
+	subs := map[string]*subscription{"1": &subscription{cancel: cancel}}
+
+	removeSubscription(&mu, subs, "1", cancel)
+
+	if _, ok := subs["1"]; ok {
+		t.Error("Expected the subscription to be removed from subs")
+		t.Fail()
+	}
+}
+
+func TestRemoveSubscriptionDoesNotClobberNewerSubscription(t *testing.T) {
+	var mu sync.Mutex
+	staleCancel := make(chan bool)
+	currentCancel := make(chan bool)
+	subs := map[string]*subscription{"1": &subscription{cancel: currentCancel}}
+
+	removeSubscription(&mu, subs, "1", staleCancel)
+
+	if _, ok := subs["1"]; !ok {
+		t.Error("Expected a subscription that reused the id to survive removal of a stale one")
+		t.Fail()
+	}
 }
\ No newline at end of file
@@ -0,0 +1,244 @@
+package goajax
+
+import (
+	"http"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"websocket"
+)
+
+// ServeWebSocket upgrades r to a WebSocket connection and serves the same
+// JSON-RPC request framing as ServeHTTP, but over a persistent full-duplex
+// connection. This is what makes streaming methods useful: a regular
+// request/response round trip still works as before, but methods whose
+// signature is func(ctx Context, args) (<-chan T, os.Error) are instead
+// treated as subscriptions, pushing each value the channel yields to the
+// client as a JSON-RPC notification carrying the original request's id. A
+// subscription ends when its channel closes, the socket closes, or the
+// client sends an "rpc.unsubscribe" request whose own id is that
+// subscription's id (its params are not read, and no reply is sent).
+func (server *Server) ServeWebSocket(w http.ResponseWriter, r *http.Request) {
+	websocket.Handler(func(ws *websocket.Conn) { server.serveWS(ws) }).ServeHTTP(w, r)
+}
+
+// subscription tracks an in-flight streaming call so rpc.unsubscribe (or
+// socket teardown) can stop it.
+type subscription struct {
+	cancel chan bool
+}
+
+// wsConn is the minimal surface subscribe needs from a WebSocket
+// connection: pushing one JSON value. Depending on this instead of a
+// concrete *websocket.Conn lets tests drive subscribe() against a fake
+// connection instead of needing a real socket.
+type wsConn interface {
+	Send(v interface{}) os.Error
+}
+
+// websocketConn adapts a real *websocket.Conn to wsConn via the same JSON
+// codec ServeWebSocket already talks over.
+type websocketConn struct {
+	ws *websocket.Conn
+}
+
+func (c websocketConn) Send(v interface{}) os.Error {
+	return websocket.JSON.Send(c.ws, v)
+}
+
+// missingSubscriptionId reports whether req lacks the id a streaming
+// subscribe needs: without one, rpc.unsubscribe (and cleanup on natural
+// completion) has no key to find it by again.
+func missingSubscriptionId(req *Request) bool {
+	return req.Id == nil || string(req.Id) == "null"
+}
+
+// removeSubscription deletes subs[id] once its subscribe call has
+// returned, but only if subs[id] still refers to this same cancel channel:
+// rpc.unsubscribe may already have removed (and the id may already have
+// been reused by) a newer subscription by the time this runs.
+func removeSubscription(mu *sync.Mutex, subs map[string]*subscription, id string, cancel chan bool) {
+	mu.Lock()
+	defer mu.Unlock()
+	if sub, ok := subs[id]; ok && sub.cancel == cancel {
+		subs[id] = nil, false
+	}
+}
+
+func (server *Server) serveWS(ws *websocket.Conn) {
+	var mu sync.Mutex
+	subs := make(map[string]*subscription)
+
+	defer func() {
+		mu.Lock()
+		for _, sub := range subs {
+			close(sub.cancel)
+		}
+		mu.Unlock()
+	}()
+
+	for {
+		wire := new(wireRequest)
+		if err := websocket.JSON.Receive(ws, wire); err != nil {
+			return
+		}
+		req := wireToConnRequest(wire)
+
+		if req.Method == "rpc.unsubscribe" {
+			id := subscriptionId(req)
+			mu.Lock()
+			if sub, ok := subs[id]; ok {
+				close(sub.cancel)
+				subs[id] = nil, false
+			}
+			mu.Unlock()
+			continue
+		}
+
+		serviceMethod := strings.Split(req.Method, ".", -1)
+		if len(serviceMethod) != 2 {
+			websocket.JSON.Send(ws, errorResponse(req, errCodeMethodNotFound, "Method not found."))
+			continue
+		}
+
+		if serviceMethod[0] == "rpc" {
+			resp := server.call(req, jsonCodec{})
+			if !isNotification(req) {
+				websocket.JSON.Send(ws, responseToWire(resp))
+			}
+			continue
+		}
+
+		server.Lock()
+		svc, ok := server.serviceMap[serviceMethod[0]]
+		server.Unlock()
+
+		if !ok {
+			websocket.JSON.Send(ws, errorResponse(req, errCodeMethodNotFound, "Service not found."))
+			continue
+		}
+
+		mtype, ok := svc.method[serviceMethod[1]]
+		if !ok {
+			websocket.JSON.Send(ws, errorResponse(req, errCodeMethodNotFound, "Method not found."))
+			continue
+		}
+
+		if mtype.streaming {
+			if missingSubscriptionId(req) {
+				websocket.JSON.Send(ws, errorResponse(req, errCodeInvalidRequest, "Subscriptions require an id."))
+				continue
+			}
+			cancel := make(chan bool)
+			id := subscriptionId(req)
+			mu.Lock()
+			subs[id] = &subscription{cancel: cancel}
+			mu.Unlock()
+			go func() {
+				server.subscribe(websocketConn{ws}, svc, mtype, req, cancel)
+				removeSubscription(&mu, subs, id, cancel)
+			}()
+			continue
+		}
+
+		resp := server.call(req, jsonCodec{})
+		if !isNotification(req) {
+			websocket.JSON.Send(ws, responseToWire(resp))
+		}
+	}
+}
+
+// wireToConnRequest adapts a request already decoded off a WebSocket
+// connection (websocket.JSON.Receive decodes straight into a wireRequest)
+// into the codec-agnostic Request shape the rest of the server deals in.
+func wireToConnRequest(wire *wireRequest) *Request {
+	req := &Request{Method: wire.Method, Timeout: wire.Timeout}
+	if wire.Id != nil {
+		req.Id = []byte(*wire.Id)
+	}
+	if wire.Params != nil {
+		req.Params = []byte(*wire.Params)
+	}
+	return req
+}
+
+func subscriptionId(req *Request) string {
+	if req.Id == nil {
+		return ""
+	}
+	return string(req.Id)
+}
+
+func errorResponse(req *Request, code int, message string) *wireResponse {
+	return responseToWire(&Response{Id: req.Id, Error: &rpcError{Code: code, Message: message}})
+}
+
+// subscribe invokes a streaming method and relays each value its channel
+// yields to ws as a notification bearing the subscription's request id,
+// until the channel closes, the call itself errors, or cancel fires.
+func (server *Server) subscribe(ws wsConn, svc *service, mtype *methodType, req *Request, cancel chan bool) {
+	args, err := jsonCodec{}.UnmarshalParams(req.Params, mtype.argTypes, mtype.argNames)
+	if err != nil {
+		ws.Send(errorResponse(req, errCodeInvalidParams, err.String()))
+		return
+	}
+
+	if mtype.hasContext {
+		ctx := &chanContext{done: cancel}
+		args = append([]reflect.Value{svc.rcvr, reflect.NewValue(Context(ctx))}, args...)
+	} else {
+		args = append([]reflect.Value{svc.rcvr}, args...)
+	}
+
+	mtype.Lock()
+	mtype.numCalls++
+	mtype.Unlock()
+
+	returnValues := mtype.method.Func.Call(args)
+
+	if errInter := returnValues[1].Interface(); errInter != nil {
+		ws.Send(errorResponse(req, errCodeInternal, errInter.(os.Error).String()))
+		return
+	}
+
+	chanValue := returnValues[0].(*reflect.ChanValue)
+
+	// stop lets the relay goroutine below give up on forwarding a value it
+	// already has in hand once this call returns; it can still be parked
+	// in chanValue.Recv() a little past that point, but only until the
+	// method's channel next emits or closes, which the method itself
+	// controls.
+	values := make(chan reflect.Value)
+	done := make(chan bool)
+	stop := make(chan bool)
+	go func() {
+		for {
+			v := chanValue.Recv()
+			if chanValue.Closed() {
+				close(done)
+				return
+			}
+			select {
+			case values <- v:
+			case <-stop:
+				return
+			}
+		}
+	}()
+	defer close(stop)
+
+	for {
+		select {
+		case v := <-values:
+			resp := responseToWire(&Response{Id: req.Id, Result: v.Interface()})
+			if err := ws.Send(resp); err != nil {
+				return
+			}
+		case <-done:
+			return
+		case <-cancel:
+			return
+		}
+	}
+}